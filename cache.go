@@ -0,0 +1,116 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// hotWindow caps how many of a room's most recent messages are kept in
+// the hot cache; anything older is served straight from the store.
+const hotWindow = 200
+
+// cacheRooms caps how many rooms' hot windows are kept in memory at
+// once; the least-recently-touched room is evicted first.
+const cacheRooms = 64
+
+type cacheEntry struct {
+	room     string
+	messages []Message
+}
+
+// messageCache is a small LRU, keyed by room name, of each room's most
+// recent messages. It sits in front of msgStore so that a redraw
+// triggered by a keystroke or a room switch doesn't have to round-trip
+// to disk every time.
+type messageCache struct {
+	mutex sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+func newMessageCache() *messageCache {
+	return &messageCache{
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *messageCache) get(room string) ([]Message, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.items[room]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return append([]Message(nil), el.Value.(*cacheEntry).messages...), true
+}
+
+// set replaces the cached window for room, trimming to hotWindow and
+// evicting the least-recently-used room if this pushes us over
+// cacheRooms.
+func (c *messageCache) set(room string, messages []Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(messages) > hotWindow {
+		messages = messages[len(messages)-hotWindow:]
+	}
+
+	if el, ok := c.items[room]; ok {
+		el.Value.(*cacheEntry).messages = messages
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&cacheEntry{room: room, messages: messages})
+	c.items[room] = el
+
+	if c.order.Len() > cacheRooms {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).room)
+	}
+}
+
+// append records a newly-posted message, if room is cached.
+func (c *messageCache) append(room string, msg Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.items[room]
+	if !ok {
+		return
+	}
+
+	entry := el.Value.(*cacheEntry)
+	entry.messages = append(entry.messages, msg)
+	if len(entry.messages) > hotWindow {
+		entry.messages = entry.messages[len(entry.messages)-hotWindow:]
+	}
+	c.order.MoveToFront(el)
+}
+
+// updateMessage patches an existing cached message (e.g. new vote
+// tallies) in place, if room is cached and the message is in its
+// window.
+func (c *messageCache) updateMessage(room string, updated Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.items[room]
+	if !ok {
+		return
+	}
+
+	entry := el.Value.(*cacheEntry)
+	for i := range entry.messages {
+		if entry.messages[i].UniqueID == updated.UniqueID {
+			entry.messages[i] = updated
+			break
+		}
+	}
+}
+
+var messageCacheInstance = newMessageCache()
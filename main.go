@@ -2,20 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/ansi"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/ssh"
@@ -23,32 +30,61 @@ import (
 	"github.com/charmbracelet/wish/activeterm"
 	"github.com/charmbracelet/wish/bubbletea"
 	"github.com/charmbracelet/wish/logging"
+	"github.com/muesli/reflow/wordwrap"
+
+	"thehiddenweb/moderation"
+	"thehiddenweb/store"
 )
 
 const (
-	host            = "0.0.0.0"
-	port            = "23234"
-	refreshInterval = 1 * time.Second
+	host = "0.0.0.0"
+	port = "23234"
 )
 
+// boxPadding is the horizontal chrome a messageBoxStyle-rendered message
+// adds around its content: one column of border plus one of padding on
+// each side. Wrapping must leave this much room or long lines overflow
+// the rounded border.
+const boxPadding = 4
+
+// Message is a single chat line, system notice, or whisper. Ephemeral
+// messages (whispers, and local notices such as command replies) are
+// never stored in a Room's history and only ever reach the one session
+// they were sent to.
 type Message struct {
 	Username  string
 	Timestamp string
+	// CreatedAt is Timestamp's full-precision source: Timestamp is only
+	// a second-granularity rendering of it for display, and must never
+	// be used as a storage or pagination key (see toStoreMessage).
+	CreatedAt time.Time
 	PubKey    string
 	Content   string
 	Upvotes   int
 	Downvotes int
 	UniqueID  string
 	System    bool
+	Ephemeral bool
+	Updated   bool
 }
 
 var (
-	chatMessages = make([]Message, 0)
-	chatMutex    = &sync.RWMutex{}
-	usersMutex   = &sync.Mutex{}
-	onlineUsers  = 0
-	userVotes    = make(map[string]map[string]int)
-	voteMutex    = &sync.Mutex{}
+	usersMutex  = &sync.Mutex{}
+	onlineUsers = 0
+
+	errAlreadyVoted    = errors.New("you have already voted this way")
+	errMessageNotFound = errors.New("message not found")
+
+	// modStore is populated in main before the server starts accepting
+	// connections.
+	modStore *moderation.Store
+
+	motdPath  string
+	motd      string
+	motdMutex = &sync.RWMutex{}
+
+	activeSessions      = make(map[string]ssh.Session)
+	activeSessionsMutex = &sync.Mutex{}
 
 	// Catppuccin Mocha color palette
 	base     = lipgloss.Color("#1E1E2E")
@@ -67,13 +103,89 @@ var (
 	messageStyle    = lipgloss.NewStyle().Foreground(text)
 	upvoteStyle     = lipgloss.NewStyle().Foreground(green)
 	downvoteStyle   = lipgloss.NewStyle().Foreground(red)
+	systemStyle     = lipgloss.NewStyle().Foreground(subtext0).Italic(true)
 	messageBoxStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(subtext0).
 			Padding(0, 1).
 			Margin(0, 0, 1, 0)
+
+	selectedMessageStyle = lipgloss.NewStyle().Background(selected).Foreground(text).Padding(0, 1)
+	selectedBoxStyle     = lipgloss.NewStyle().
+				Border(lipgloss.RoundedBorder()).
+				BorderForeground(red).
+				Padding(0, 1).
+				Margin(0, 0, 1, 0)
 )
 
+// strPtr is a small helper for populating glamour's *string style fields
+// from the Catppuccin lipgloss.Color constants above.
+func strPtr(c lipgloss.Color) *string {
+	s := string(c)
+	return &s
+}
+
+// catppuccinGlamourStyle is a minimal glamour style built from scratch
+// with the Catppuccin Mocha palette already used everywhere else in this
+// file. It styles the document, paragraphs, headings, emphasis, links
+// and code; elements it doesn't set are rendered unstyled, not taken
+// from glamour's built-in dark style.
+var catppuccinGlamourStyle = func() ansi.StyleConfig {
+	s := ansi.StyleConfig{
+		Document: ansi.StyleBlock{
+			StylePrimitive: ansi.StylePrimitive{Color: strPtr(text)},
+		},
+		Paragraph: ansi.StyleBlock{
+			StylePrimitive: ansi.StylePrimitive{Color: strPtr(text)},
+		},
+		Heading: ansi.StyleBlock{
+			StylePrimitive: ansi.StylePrimitive{
+				Color: strPtr(lavender),
+				Bold:  boolPtr(true),
+			},
+		},
+		H1: ansi.StyleBlock{
+			StylePrimitive: ansi.StylePrimitive{
+				Prefix:          " ",
+				Suffix:          " ",
+				Color:           strPtr(base),
+				BackgroundColor: strPtr(lavender),
+				Bold:            boolPtr(true),
+			},
+		},
+		Strong: ansi.StylePrimitive{Color: strPtr(green), Bold: boolPtr(true)},
+		Emph:   ansi.StylePrimitive{Color: strPtr(peach), Italic: boolPtr(true)},
+		Link:   ansi.StylePrimitive{Color: strPtr(blue), Underline: boolPtr(true)},
+		Code: ansi.StyleBlock{
+			StylePrimitive: ansi.StylePrimitive{Color: strPtr(peach)},
+		},
+		CodeBlock: ansi.StyleCodeBlock{
+			StyleBlock: ansi.StyleBlock{
+				StylePrimitive: ansi.StylePrimitive{Color: strPtr(text)},
+			},
+		},
+	}
+	return s
+}()
+
+// boolPtr is strPtr's counterpart for glamour's *bool style fields.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// renderMarkdown renders content as markdown through glamour, word-wrapped
+// to width and styled with catppuccinGlamourStyle.
+func renderMarkdown(content string, width int) (string, error) {
+	r, err := glamour.NewTermRenderer(
+		glamour.WithStyles(catppuccinGlamourStyle),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return "", err
+	}
+	return r.Render(content)
+}
+
 func incrementUsers() {
 	fmt.Println("incrementing users")
 	usersMutex.Lock()
@@ -90,100 +202,330 @@ func decrementUsers() {
 	}
 }
 
-func generateUniqueMessageID(msg Message) string {
-	hash := sha256.New()
-	hash.Write([]byte(fmt.Sprintf("%s%s%s", msg.Username, msg.Timestamp, msg.Content)))
-	return fmt.Sprintf("%x", hash.Sum(nil))
+// generateUniqueMessageID returns a random identifier for msgStore's
+// primary key. It's intentionally not derived from the message's
+// content or timestamp: two messages posted in the same room within
+// the same instant (e.g. two anonymous sessions both joining, which
+// produce byte-identical content) must still get distinct IDs, or the
+// second one fails to persist.
+func generateUniqueMessageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// fingerprintOf returns the SHA256 fingerprint of key, or "" if key is
+// nil (e.g. a session that authenticated without a public key).
+func fingerprintOf(key ssh.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(key.Marshal()))
+}
+
+// messageTimeLayout is how Message.Timestamp (a rendering of CreatedAt,
+// not a storage key) is formatted for display.
+const messageTimeLayout = "2006-01-02 15:04:05"
+
+// Timestamp display modes, cycled by Ctrl+T and persisted per pubkey.
+const (
+	timestampOff = iota
+	timestampShort
+	timestampFull
+)
+
+// buildMessage stamps out a Message for content posted by session (nil
+// for server-generated system messages). Every message gets a unique
+// ID, system or not, since it doubles as the storage row's primary key.
+func buildMessage(session ssh.Session, content string, system bool) Message {
+	now := time.Now()
+	pubKey := "N/A"
+	username := "Anonymous"
+
+	if session != nil {
+		username = session.User()
+		if fp := fingerprintOf(session.PublicKey()); fp != "" {
+			pubKey = fp
+			username = displayName(pubKey, username)
+		}
+	}
+
+	return Message{
+		Username:  username,
+		Timestamp: now.Format(messageTimeLayout),
+		CreatedAt: now,
+		PubKey:    pubKey,
+		Content:   content,
+		System:    system,
+		UniqueID:  generateUniqueMessageID(),
+	}
 }
 
-func voteMessage(session ssh.Session, messageID string, voteType int) error {
-	voteMutex.Lock()
-	defer voteMutex.Unlock()
+// profileCache mirrors the durable store's profiles in memory, since
+// displayName is on the hot path for every message and status line.
+var (
+	profileCacheMutex = &sync.Mutex{}
+	profileCache      = make(map[string]store.Profile)
+)
 
-	username := session.User()
-	userVotesForMessage, exists := userVotes[username]
-	if !exists {
-		userVotes[username] = make(map[string]int)
-		userVotesForMessage = userVotes[username]
+// getProfile returns pubKey's profile, from profileCache if present and
+// from msgStore (populating the cache) otherwise. A pubKey that has
+// never set a profile gets one back with only PubKey set, not an error.
+func getProfile(pubKey string) store.Profile {
+	if pubKey == "" || pubKey == "N/A" {
+		return store.Profile{}
 	}
 
-	// Check if user has already voted this way
-	if userVotesForMessage[messageID] == voteType {
-		return errors.New("you have already voted this way")
+	profileCacheMutex.Lock()
+	if p, ok := profileCache[pubKey]; ok {
+		profileCacheMutex.Unlock()
+		return p
 	}
+	profileCacheMutex.Unlock()
 
-	chatMutex.Lock()
-	defer chatMutex.Unlock()
+	p, ok, err := msgStore.GetProfile(pubKey)
+	if err != nil {
+		log.Error("failed to load profile", "pubkey", pubKey, "error", err)
+		return store.Profile{}
+	}
+	if !ok {
+		p = store.Profile{PubKey: pubKey}
+	}
 
-	for i, msg := range chatMessages {
-		if msg.UniqueID == messageID {
-			// Remove previous vote if exists
-			if prevVote, exists := userVotesForMessage[messageID]; exists {
-				if prevVote > 0 {
-					chatMessages[i].Upvotes--
-				} else if prevVote < 0 {
-					chatMessages[i].Downvotes--
-				}
-			}
+	profileCacheMutex.Lock()
+	profileCache[pubKey] = p
+	profileCacheMutex.Unlock()
+	return p
+}
 
-			// Add new vote
-			if voteType > 0 {
-				chatMessages[i].Upvotes++
-			} else {
-				chatMessages[i].Downvotes++
-			}
+// setProfile persists p and updates profileCache to match.
+func setProfile(p store.Profile) error {
+	if err := msgStore.SetProfile(p); err != nil {
+		return err
+	}
+	profileCacheMutex.Lock()
+	profileCache[p.PubKey] = p
+	profileCacheMutex.Unlock()
+	return nil
+}
 
-			// Update user's vote
-			userVotesForMessage[messageID] = voteType
-			return nil
+// displayName resolves pubKey's nickname if one has been set, falling
+// back to fallback (the connecting SSH username).
+func displayName(pubKey, fallback string) string {
+	if nick := getProfile(pubKey).Nick; nick != "" {
+		return nick
+	}
+	return fallback
+}
+
+// reservedNicks can never be claimed with /nick.
+var reservedNicks = map[string]bool{
+	"system":    true,
+	"anonymous": true,
+	"admin":     true,
+	"server":    true,
+	"all":       true,
+	"here":      true,
+}
+
+// validateNick rejects anything too long, containing whitespace, or
+// reserved; it does not check for uniqueness, which depends on who else
+// is currently online.
+func validateNick(nick string) error {
+	if nick == "" || len(nick) > 20 {
+		return errors.New("nickname must be 1-20 characters")
+	}
+	if strings.ContainsAny(nick, " \t\n") {
+		return errors.New("nickname must not contain whitespace")
+	}
+	if reservedNicks[strings.ToLower(nick)] {
+		return errors.New("that nickname is reserved")
+	}
+	return nil
+}
+
+// anySessionMatches reports whether match returns true for any
+// currently-registered session.
+func anySessionMatches(match func(sessionID string, s ssh.Session) bool) bool {
+	activeSessionsMutex.Lock()
+	defer activeSessionsMutex.Unlock()
+
+	for id, s := range activeSessions {
+		if match(id, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// nickTaken reports whether nick is already, case-insensitively, the
+// resolved display name of some other connected session.
+func nickTaken(nick, exceptSessionID string) bool {
+	return anySessionMatches(func(id string, s ssh.Session) bool {
+		if id == exceptSessionID {
+			return false
 		}
+		return strings.EqualFold(displayName(fingerprintOf(s.PublicKey()), s.User()), nick)
+	})
+}
+
+// remoteIP extracts the bare IP (no port) a session or pre-auth context
+// connected from.
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
 	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// authorize is shared by the public-key and password auth handlers: a
+// connection is let through unless it's banned, or whitelist mode is on
+// and it isn't on the whitelist.
+func authorize(ctx ssh.Context, fingerprint string) bool {
+	ip := remoteIP(ctx.RemoteAddr())
+	username := displayName(fingerprint, ctx.User())
 
-	return errors.New("message not found")
+	if ban, banned := modStore.IsBanned(fingerprint, ip, username); banned {
+		log.Info("rejected banned connection", "user", username, "type", ban.Type, "value", ban.Value)
+		return false
+	}
+	if !modStore.IsWhitelisted(fingerprint, username) {
+		log.Info("rejected non-whitelisted connection", "user", username)
+		return false
+	}
+	return true
 }
 
-func addMessage(session ssh.Session, message string, system bool) {
-	chatMutex.Lock()
-	defer chatMutex.Unlock()
+// offeredFingerprintKey records the fingerprint of any public key
+// offered during this connection's auth attempts, so a later fallback
+// to password auth on the same connection can still be checked against
+// it even though that attempt carries no key of its own.
+type offeredFingerprintKey struct{}
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	pubKey := "N/A"
-	username := "Anonymous"
+func publicKeyAuthHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	fingerprint := fingerprintOf(key)
+	ctx.SetValue(offeredFingerprintKey{}, fingerprint)
+	return authorize(ctx, fingerprint)
+}
 
-	if session != nil {
-		username = session.User()
-		if key := session.PublicKey(); key != nil {
-			pubKey = fmt.Sprintf("%x", sha256.Sum256(key.Marshal()))
+// passwordAuthHandler accepts any password once the ban/whitelist checks
+// pass; the password itself is never checked. Those checks use the
+// fingerprint of a key offered earlier in this connection's auth
+// attempts, if any — but a client that never offers its key (e.g. with
+// its agent disabled) authenticates with fingerprint "", so fingerprint
+// bans alone are not sufficient to keep someone out; pair them with an
+// IP or username ban for that.
+func passwordAuthHandler(ctx ssh.Context, _ string) bool {
+	fingerprint, _ := ctx.Value(offeredFingerprintKey{}).(string)
+	return authorize(ctx, fingerprint)
+}
+
+// registerSession and unregisterSession back the live session registry
+// used to drop connections when a ban is issued or an admin /kicks
+// someone.
+func registerSession(sessionID string, s ssh.Session) {
+	activeSessionsMutex.Lock()
+	activeSessions[sessionID] = s
+	activeSessionsMutex.Unlock()
+}
+
+func unregisterSession(sessionID string) {
+	activeSessionsMutex.Lock()
+	delete(activeSessions, sessionID)
+	activeSessionsMutex.Unlock()
+}
+
+// closeSessionsMatching closes every active session match accepts,
+// reporting whether it closed any at all.
+func closeSessionsMatching(match func(ssh.Session) bool) bool {
+	activeSessionsMutex.Lock()
+	defer activeSessionsMutex.Unlock()
+
+	closed := false
+	for _, s := range activeSessions {
+		if match(s) {
+			_ = s.Close()
+			closed = true
 		}
 	}
+	return closed
+}
 
-	msg := Message{
-		Username:  username,
-		Timestamp: timestamp,
-		PubKey:    pubKey,
-		Content:   message,
-		Upvotes:   0,
-		Downvotes: 0,
-		System:    system,
+// banMatcher turns a persisted Ban back into a predicate over live
+// sessions, used to drop anyone it now covers.
+func banMatcher(ban moderation.Ban) func(ssh.Session) bool {
+	return func(s ssh.Session) bool {
+		switch ban.Type {
+		case moderation.KeyUsername:
+			return strings.EqualFold(displayName(fingerprintOf(s.PublicKey()), s.User()), ban.Value)
+		case moderation.KeyIP:
+			return remoteIP(s.RemoteAddr()) == ban.Value
+		case moderation.KeyFingerprint:
+			return fingerprintOf(s.PublicKey()) == ban.Value
+		default:
+			return false
+		}
 	}
+}
 
-	if !system {
-		msg.UniqueID = generateUniqueMessageID(msg)
+// loadMOTD reads path, falling back to the original hard-coded welcome
+// if it doesn't exist yet.
+func loadMOTD(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "Welcome to letsgosky.social"
 	}
+	return strings.TrimSpace(string(data))
+}
 
-	chatMessages = append(chatMessages, msg)
+func getMOTD() string {
+	motdMutex.RLock()
+	defer motdMutex.RUnlock()
+	return motd
 }
 
-func getMessages() []Message {
-	chatMutex.RLock()
-	defer chatMutex.RUnlock()
-	return append([]Message(nil), chatMessages...)
+func setMOTD(path, text string) error {
+	motdMutex.Lock()
+	motd = text
+	motdMutex.Unlock()
+	return os.WriteFile(path, []byte(text+"\n"), 0o644)
 }
 
 func main() {
+	flag.StringVar(&motdPath, "motd", "./motd.txt", "path to a file whose contents greet each new session")
+	modFile := flag.String("modfile", "./moderation.json", "path to the persistent ban/admin/whitelist store")
+	dbFile := flag.String("db", "./chat.db", "path to the SQLite database holding chat history and votes")
+	flag.Parse()
+
+	motd = loadMOTD(motdPath)
+
+	var err error
+	modStore, err = moderation.Load(*modFile)
+	if err != nil {
+		log.Error("Could not load moderation store", "error", err)
+		return
+	}
+	modStore.OnBan(func(ban moderation.Ban) {
+		closeSessionsMatching(banMatcher(ban))
+	})
+
+	sqliteStore, err := store.OpenSQLite(*dbFile)
+	if err != nil {
+		log.Error("Could not open message store", "error", err)
+		return
+	}
+	defer sqliteStore.Close()
+	msgStore = sqliteStore
+
 	s, err := wish.NewServer(
 		wish.WithAddress(net.JoinHostPort(host, port)),
 		wish.WithHostKeyPath(".ssh/id_ed25519"),
+		wish.WithPublicKeyAuth(publicKeyAuthHandler),
+		wish.WithPasswordAuth(passwordAuthHandler),
 		wish.WithMiddleware(
 			bubbletea.Middleware(teaHandler),
 			activeterm.Middleware(),
@@ -195,8 +537,6 @@ func main() {
 		return
 	}
 
-	addMessage(nil, "Welcome to letsgosky.social", true)
-
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	log.Info("Starting SSH chat server", "host", host, "port", port)
@@ -218,7 +558,9 @@ func main() {
 }
 
 func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
-	addMessage(nil, fmt.Sprintf("Silly goober %s has made the mistake of joining the cult", s.User()), true)
+	room := getOrCreateRoom(defaultRoom)
+	room.AddMessage(nil, fmt.Sprintf("Silly goober %s has made the mistake of joining the cult",
+		displayName(fingerprintOf(s.PublicKey()), s.User())), true)
 
 	incrementUsers()
 
@@ -242,28 +584,151 @@ func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
 
 	vp := viewport.New(pty.Window.Width, pty.Window.Height-5)
 
-	existingMessages := getMessages()
+	sessionID := newSessionID()
+	registerSession(sessionID, s)
+
+	fingerprint := fingerprintOf(s.PublicKey())
+	profile := getProfile(fingerprint)
+
+	history := room.GetMessages()
+
+	helpModel := help.New()
+	helpModel.Width = pty.Window.Width
 
 	m := model{
-		session:     s,
-		viewport:    vp,
-		textarea:    ta,
-		senderStyle: senderStyle,
-		textStyle:   textStyle,
-		quitStyle:   quitStyle,
-		term:        pty.Term,
-		width:       pty.Window.Width,
-		height:      pty.Window.Height,
-	}
-
-	m.viewport.SetContent(m.formatMessages(existingMessages))
+		session:       s,
+		sessionID:     sessionID,
+		fingerprint:   fingerprint,
+		room:          room,
+		roomName:      defaultRoom,
+		msgChan:       room.Subscribe(sessionID, displayName(fingerprint, s.User())),
+		history:       history,
+		renderCache:   make(map[string]cachedRender),
+		timestampMode: profile.TimestampMode,
+		keys:          defaultKeyMap,
+		help:          helpModel,
+		viewport:      vp,
+		textarea:      ta,
+		senderStyle:   senderStyle,
+		textStyle:     textStyle,
+		quitStyle:     quitStyle,
+		term:          pty.Term,
+		width:         pty.Window.Width,
+		height:        pty.Window.Height,
+	}
+	m.oldestLoaded = oldestTimestamp(history)
+
+	m.notice(getMOTD())
+	m.refreshViewport()
 	m.viewport.GotoBottom()
 
 	return m, []tea.ProgramOption{tea.WithAltScreen()}
 }
 
+// keyMap is the single source of truth for every keybinding Update acts
+// on and help.Model renders. A couple of entries (NickCmd, RoomCmd) have
+// no bound keys at all — they exist purely so the slash commands show up
+// next to the real keybindings in the full help view.
+type keyMap struct {
+	Send       key.Binding
+	ToggleMode key.Binding
+	Up         key.Binding
+	Down       key.Binding
+	Upvote     key.Binding
+	Downvote   key.Binding
+	Timestamps key.Binding
+	Help       key.Binding
+	Quit       key.Binding
+	NickCmd    key.Binding
+	RoomCmd    key.Binding
+}
+
+var defaultKeyMap = keyMap{
+	Send: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "send message"),
+	),
+	ToggleMode: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "toggle select mode"),
+	),
+	Up: key.NewBinding(
+		key.WithKeys("up"),
+		key.WithHelp("↑", "select previous"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down"),
+		key.WithHelp("↓", "select next"),
+	),
+	Upvote: key.NewBinding(
+		key.WithKeys("u", "U"),
+		key.WithHelp("u", "upvote selected"),
+	),
+	Downvote: key.NewBinding(
+		key.WithKeys("d", "D"),
+		key.WithHelp("d", "downvote selected"),
+	),
+	Timestamps: key.NewBinding(
+		key.WithKeys("ctrl+t"),
+		key.WithHelp("ctrl+t", "cycle timestamps"),
+	),
+	Help: key.NewBinding(
+		key.WithKeys("?"),
+		key.WithHelp("?", "toggle help"),
+	),
+	Quit: key.NewBinding(
+		key.WithKeys("esc", "ctrl+c"),
+		key.WithHelp("esc/ctrl+c", "quit"),
+	),
+	NickCmd: key.NewBinding(
+		key.WithHelp("/nick <name>", "set your nickname"),
+	),
+	RoomCmd: key.NewBinding(
+		key.WithHelp("/rooms /join /leave /who /msg", "manage rooms"),
+	),
+}
+
+// ShortHelp implements help.KeyMap.
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.ToggleMode, k.Help, k.Quit}
+}
+
+// FullHelp implements help.KeyMap.
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Send, k.ToggleMode, k.Timestamps},
+		{k.Up, k.Down, k.Upvote, k.Downvote},
+		{k.NickCmd, k.RoomCmd},
+		{k.Help, k.Quit},
+	}
+}
+
 type model struct {
-	session          ssh.Session
+	session     ssh.Session
+	sessionID   string
+	fingerprint string
+	room        *Room
+	roomName    string
+	msgChan     chan Message
+
+	// history is this session's own view of the room: the hot window
+	// loaded at join/switch time, extended upward with whatever older
+	// pages the user has scrolled far enough to lazy-load.
+	history      []Message
+	oldestLoaded time.Time
+	loadingOlder bool
+	noMoreOlder  bool
+
+	notices          []Message
+
+	// renderCache holds one formatted message per UniqueID, reused as
+	// long as nothing that affects its rendering (votes, selection,
+	// viewport width) has changed since it was built.
+	renderCache map[string]cachedRender
+
+	keys keyMap
+	help help.Model
+
 	viewport         viewport.Model
 	textarea         textarea.Model
 	senderStyle      lipgloss.Style
@@ -273,24 +738,321 @@ type model struct {
 	width            int
 	height           int
 	err              error
-	lastMessageCount int
 	selectedMessage  int
 	isSelectMode     bool
+	timestampMode    int
+}
+
+// cachedRender is one rendered message, along with the inputs that
+// produced it. It's reused verbatim until any of those inputs change.
+type cachedRender struct {
+	upvotes   int
+	downvotes int
+	selected  bool
+	width     int
+	rendered  string
+}
+
+// oldestTimestamp returns the CreatedAt of the earliest message in
+// messages (they're in chronological order), or now if there are none.
+func oldestTimestamp(messages []Message) time.Time {
+	if len(messages) == 0 {
+		return time.Now()
+	}
+	return messages[0].CreatedAt
 }
 
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		textarea.Blink,
-		m.checkNewMessages,
+		waitForRoomMessage(m.msgChan),
 	)
 }
 
-type messagesUpdatedMsg struct{}
+// roomMessageMsg is pushed whenever a subscribed Room broadcasts or
+// whispers a Message, replacing the old 1s poll.
+type roomMessageMsg Message
+
+// waitForRoomMessage blocks on ch until either a message arrives or the
+// room closes it on Unsubscribe, in which case it returns nil so the
+// listening goroutine exits cleanly.
+func waitForRoomMessage(ch chan Message) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return roomMessageMsg(msg)
+	}
+}
+
+// renderContent formats the session's loaded history plus any pending
+// ephemeral notices (whispers, command replies) for the viewport.
+func (m *model) renderContent() string {
+	content := m.formatMessages(m.history)
+
+	if len(m.notices) > 0 {
+		noticeLines := make([]string, 0, len(m.notices))
+		for _, n := range m.notices {
+			noticeLines = append(noticeLines, systemStyle.Render(n.Content))
+		}
+		content = content + "\n" + strings.Join(noticeLines, "\n")
+	}
+
+	return content
+}
+
+// refreshViewport re-renders and snaps to the bottom, for anything that
+// should read as "you're caught up" (a new message, a vote, a command
+// reply). Loading an older page instead preserves scroll position; see
+// the olderMessagesMsg case in Update.
+func (m *model) refreshViewport() {
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+// notice appends a local, ephemeral system line (never broadcast,
+// never persisted) such as a command reply or a whisper.
+func (m *model) notice(content string) {
+	m.notices = append(m.notices, Message{System: true, Ephemeral: true, Content: content})
+	if len(m.notices) > 50 {
+		m.notices = m.notices[len(m.notices)-50:]
+	}
+}
+
+// switchRoom moves the session from its current room into name,
+// creating the room if necessary and resubscribing the message feed.
+func (m *model) switchRoom(name string) {
+	if name == m.roomName {
+		m.notice("already in " + name)
+		return
+	}
+
+	m.room.Unsubscribe(m.sessionID)
+	m.room.AddMessage(nil, fmt.Sprintf("%s left %s", displayName(m.fingerprint, m.session.User()), m.roomName), true)
+
+	next := getOrCreateRoom(name)
+	m.room = next
+	m.roomName = name
+	m.msgChan = next.Subscribe(m.sessionID, displayName(m.fingerprint, m.session.User()))
+	m.notices = nil
+	m.selectedMessage = 0
+
+	next.AddMessage(nil, fmt.Sprintf("%s joined %s", displayName(m.fingerprint, m.session.User()), name), true)
+
+	m.history = next.GetMessages()
+	m.oldestLoaded = oldestTimestamp(m.history)
+	m.loadingOlder = false
+	m.noMoreOlder = false
+
+	m.refreshViewport()
+}
+
+// handleCommand parses and executes a leading-slash chat command,
+// returning the updated model and any follow-up command needed (e.g.
+// resubscribing the message feed after a room switch).
+func (m model) handleCommand(input string) (model, tea.Cmd) {
+	fields := strings.Fields(input)
+	name := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	switch name {
+	case "/rooms":
+		counts := listRooms()
+		roomNames := make([]string, 0, len(counts))
+		for n := range counts {
+			roomNames = append(roomNames, n)
+		}
+		sort.Strings(roomNames)
+
+		var b strings.Builder
+		b.WriteString("rooms:")
+		for _, n := range roomNames {
+			fmt.Fprintf(&b, " %s(%d)", n, counts[n])
+		}
+		m.notice(b.String())
+
+	case "/join":
+		if len(args) != 1 {
+			m.notice("usage: /join <name>")
+			break
+		}
+		if err := validateRoomName(args[0]); err != nil {
+			m.notice(err.Error())
+			break
+		}
+		m.switchRoom(args[0])
+		return m, waitForRoomMessage(m.msgChan)
+
+	case "/leave":
+		m.switchRoom(defaultRoom)
+		return m, waitForRoomMessage(m.msgChan)
+
+	case "/who":
+		m.notice(fmt.Sprintf("in %s: %s", m.roomName, strings.Join(m.room.Who(), ", ")))
+
+	case "/msg":
+		if len(args) < 2 {
+			m.notice("usage: /msg <user> <text>")
+			break
+		}
+		target := args[0]
+		text := strings.Join(args[1:], " ")
+		whisper := Message{
+			System:    true,
+			Ephemeral: true,
+			Content:   fmt.Sprintf("[whisper from %s] %s", displayName(m.fingerprint, m.session.User()), text),
+		}
+		if !m.room.Whisper(target, whisper) {
+			m.notice("no such user in this room: " + target)
+		}
+
+	case "/nick":
+		if len(args) != 1 {
+			m.notice("usage: /nick <name>")
+			break
+		}
+		newNick := args[0]
+		if err := validateNick(newNick); err != nil {
+			m.notice(err.Error())
+			break
+		}
+		if m.fingerprint == "" {
+			m.notice("connect with a public key to set a nickname")
+			break
+		}
+		if nickTaken(newNick, m.sessionID) {
+			m.notice("nickname already in use: " + newNick)
+			break
+		}
+
+		profile := getProfile(m.fingerprint)
+		profile.PubKey = m.fingerprint
+		profile.Nick = newNick
+		if err := setProfile(profile); err != nil {
+			m.notice("nickname update failed: " + err.Error())
+			break
+		}
+		m.room.Rename(m.sessionID, newNick)
+		m.notice("nickname set to " + newNick)
+
+	case "/ban", "/unban", "/kick", "/motd", "/op":
+		if !modStore.IsAdmin(m.fingerprint) {
+			m.notice("permission denied")
+			break
+		}
+		m.handleAdminCommand(name, args)
+
+	default:
+		m.notice("unknown command: " + name)
+	}
+
+	m.refreshViewport()
+	return m, nil
+}
+
+// parseKeyType maps the first argument of /ban and /unban to a
+// moderation.KeyType.
+func parseKeyType(s string) (moderation.KeyType, error) {
+	switch strings.ToLower(s) {
+	case "user", "username":
+		return moderation.KeyUsername, nil
+	case "ip":
+		return moderation.KeyIP, nil
+	case "fp", "fingerprint":
+		return moderation.KeyFingerprint, nil
+	default:
+		return "", fmt.Errorf("unknown key type %q (want user|ip|fp)", s)
+	}
+}
+
+// handleAdminCommand executes a slash command already known to require
+// admin rights; callers must check modStore.IsAdmin first.
+func (m *model) handleAdminCommand(name string, args []string) {
+	switch name {
+	case "/ban":
+		if len(args) < 2 {
+			m.notice("usage: /ban <user|ip|fp> <target> [duration]")
+			return
+		}
+		keyType, err := parseKeyType(args[0])
+		if err != nil {
+			m.notice(err.Error())
+			return
+		}
+
+		var duration time.Duration
+		if len(args) >= 3 {
+			duration, err = time.ParseDuration(args[2])
+			if err != nil {
+				m.notice("bad duration: " + err.Error())
+				return
+			}
+		}
+
+		reason := "banned by " + m.session.User()
+		if err := modStore.Ban(keyType, args[1], reason, duration); err != nil {
+			m.notice("ban failed: " + err.Error())
+			return
+		}
+		m.notice(fmt.Sprintf("banned %s %s", args[0], args[1]))
+
+	case "/unban":
+		if len(args) < 2 {
+			m.notice("usage: /unban <user|ip|fp> <target>")
+			return
+		}
+		keyType, err := parseKeyType(args[0])
+		if err != nil {
+			m.notice(err.Error())
+			return
+		}
+		if err := modStore.Unban(keyType, args[1]); err != nil {
+			m.notice("unban failed: " + err.Error())
+			return
+		}
+		m.notice(fmt.Sprintf("unbanned %s %s", args[0], args[1]))
 
-func (m model) checkNewMessages() tea.Msg {
-	time.Sleep(refreshInterval)
-	return messagesUpdatedMsg{}
+	case "/kick":
+		if len(args) != 1 {
+			m.notice("usage: /kick <user>")
+			return
+		}
+		target := args[0]
+		closed := closeSessionsMatching(func(s ssh.Session) bool {
+			return strings.EqualFold(displayName(fingerprintOf(s.PublicKey()), s.User()), target)
+		})
+		if closed {
+			m.notice("kicked " + target)
+		} else {
+			m.notice("no such user: " + target)
+		}
+
+	case "/motd":
+		if len(args) == 0 {
+			m.notice("motd: " + getMOTD())
+			return
+		}
+		text := strings.Join(args, " ")
+		if err := setMOTD(motdPath, text); err != nil {
+			m.notice("motd update failed: " + err.Error())
+			return
+		}
+		getOrCreateRoom(defaultRoom).AddMessage(nil, "MOTD updated by "+m.session.User(), true)
+
+	case "/op":
+		if len(args) != 1 {
+			m.notice("usage: /op <fingerprint>")
+			return
+		}
+		if err := modStore.AddAdmin(args[0]); err != nil {
+			m.notice("op failed: " + err.Error())
+			return
+		}
+		m.notice("granted admin to " + args[0])
+	}
 }
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		tiCmd tea.Cmd
@@ -310,158 +1072,297 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.textarea.SetWidth(msg.Width - 2)
 		m.viewport.Width = msg.Width
 		m.viewport.Height = msg.Height - 5
+		m.help.Width = msg.Width
+		// Every cached render was wrapped for the old width.
+		m.renderCache = make(map[string]cachedRender)
 
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			m.room.Unsubscribe(m.sessionID)
+			unregisterSession(m.sessionID)
 			decrementUsers()
 			return m, tea.Quit
 
-		case tea.KeyTab:
+		case key.Matches(msg, m.keys.Help):
+			if m.isSelectMode {
+				m.help.ShowAll = !m.help.ShowAll
+			}
+
+		case key.Matches(msg, m.keys.ToggleMode):
 			// Toggle between input and selection modes
 			m.isSelectMode = !m.isSelectMode
 			if m.isSelectMode {
 				m.textarea.Blur()
 				// Initialize selected message to the last message
-				m.selectedMessage = len(chatMessages) - 1
+				m.selectedMessage = len(m.history) - 1
 			} else {
 				m.textarea.Focus()
 			}
 
-		case tea.KeyEnter:
-			if !m.isSelectMode && m.textarea.Value() != "" {
-				addMessage(m.session, m.textarea.Value(), false)
+		case key.Matches(msg, m.keys.Timestamps):
+			m.timestampMode = (m.timestampMode + 1) % 3
+			if m.fingerprint != "" {
+				profile := getProfile(m.fingerprint)
+				profile.PubKey = m.fingerprint
+				profile.TimestampMode = m.timestampMode
+				if err := setProfile(profile); err != nil {
+					log.Error("failed to persist timestamp mode", "error", err)
+				}
+			}
+			// Every cached render embedded the old timestamp mode.
+			m.renderCache = make(map[string]cachedRender)
+			m.refreshViewport()
 
-				updatedMessages := getMessages()
-				m.viewport.SetContent(m.formatMessages(updatedMessages))
+		case key.Matches(msg, m.keys.Send):
+			if !m.isSelectMode && m.textarea.Value() != "" {
+				input := m.textarea.Value()
 				m.textarea.Reset()
-				m.viewport.GotoBottom()
+
+				if strings.HasPrefix(input, "/") {
+					return m.handleCommand(input)
+				}
+
+				sent, err := m.room.AddMessage(m.session, input, false)
+				if err != nil {
+					m.notice("failed to send message: " + err.Error())
+				} else {
+					m.history = append(m.history, sent)
+				}
+				m.refreshViewport()
 			}
 
-		default:
+		case key.Matches(msg, m.keys.Up):
 			if m.isSelectMode {
-				switch msg.Type {
-				case tea.KeyUp:
-					for {
-						if m.selectedMessage > 0 {
-							m.selectedMessage--
-						}
-						if m.selectedMessage == 0 || !chatMessages[m.selectedMessage].System {
-							break
-						}
+				messages := m.history
+				for {
+					if m.selectedMessage > 0 {
+						m.selectedMessage--
 					}
-					m.viewport.SetContent(m.formatMessages(getMessages()))
-
-				case tea.KeyDown:
-					for {
-						if m.selectedMessage < len(chatMessages)-1 {
-							m.selectedMessage++
-						}
-						if m.selectedMessage == len(chatMessages)-1 || !chatMessages[m.selectedMessage].System {
-							break
-						}
+					if m.selectedMessage == 0 || !messages[m.selectedMessage].System {
+						break
+					}
+				}
+				m.refreshViewport()
+			}
+
+		case key.Matches(msg, m.keys.Down):
+			if m.isSelectMode {
+				messages := m.history
+				for {
+					if m.selectedMessage < len(messages)-1 {
+						m.selectedMessage++
 					}
-					m.viewport.SetContent(m.formatMessages(getMessages()))
-
-				case tea.KeyRunes:
-					switch msg.String() {
-					case "u", "U":
-						if len(chatMessages) > m.selectedMessage {
-							selectedMsg := chatMessages[m.selectedMessage]
-							err := voteMessage(m.session, selectedMsg.UniqueID, 1)
-							if err != nil {
-								m.err = err
-							} else {
-								updatedMessages := getMessages()
-								m.viewport.SetContent(m.formatMessages(updatedMessages))
-							}
-						}
-
-					case "d", "D":
-						if len(chatMessages) > m.selectedMessage {
-							selectedMsg := chatMessages[m.selectedMessage]
-							err := voteMessage(m.session, selectedMsg.UniqueID, -1)
-							if err != nil {
-								m.err = err
-							} else {
-								updatedMessages := getMessages()
-								m.viewport.SetContent(m.formatMessages(updatedMessages))
-							}
-						}
+					if m.selectedMessage == len(messages)-1 || !messages[m.selectedMessage].System {
+						break
 					}
 				}
+				m.refreshViewport()
+			}
+
+		case key.Matches(msg, m.keys.Upvote):
+			if m.isSelectMode && len(m.history) > m.selectedMessage {
+				selectedMsg := m.history[m.selectedMessage]
+				if _, err := m.room.Vote(m.session.User(), selectedMsg.UniqueID, 1); err != nil {
+					m.err = err
+				} else {
+					delete(m.renderCache, selectedMsg.UniqueID)
+					m.refreshViewport()
+				}
+			}
+
+		case key.Matches(msg, m.keys.Downvote):
+			if m.isSelectMode && len(m.history) > m.selectedMessage {
+				selectedMsg := m.history[m.selectedMessage]
+				if _, err := m.room.Vote(m.session.User(), selectedMsg.UniqueID, -1); err != nil {
+					m.err = err
+				} else {
+					delete(m.renderCache, selectedMsg.UniqueID)
+					m.refreshViewport()
+				}
 			}
 		}
 
-	case messagesUpdatedMsg:
-		updatedMessages := getMessages()
-		if len(updatedMessages) != m.lastMessageCount {
-			m.viewport.SetContent(m.formatMessages(updatedMessages))
-			m.viewport.GotoBottom()
-			m.lastMessageCount = len(updatedMessages)
+	case roomMessageMsg:
+		chatMsg := Message(msg)
+		switch {
+		case chatMsg.Ephemeral:
+			m.notices = append(m.notices, chatMsg)
+			if len(m.notices) > 50 {
+				m.notices = m.notices[len(m.notices)-50:]
+			}
+		case chatMsg.Updated:
+			delete(m.renderCache, chatMsg.UniqueID)
+			for i := range m.history {
+				if m.history[i].UniqueID == chatMsg.UniqueID {
+					m.history[i] = chatMsg
+					break
+				}
+			}
+		default:
+			alreadyHave := false
+			for _, existing := range m.history {
+				if existing.UniqueID == chatMsg.UniqueID {
+					alreadyHave = true
+					break
+				}
+			}
+			if !alreadyHave {
+				m.history = append(m.history, chatMsg)
+			}
+		}
+		m.refreshViewport()
+		return m, tea.Batch(tiCmd, vpCmd, waitForRoomMessage(m.msgChan))
+
+	case olderMessagesMsg:
+		m.loadingOlder = false
+		older := []Message(msg)
+		if len(older) == 0 {
+			m.noMoreOlder = true
+			break
+		}
 
-			m.selectedMessage = len(updatedMessages) - 1
+		addedLines := strings.Count(m.formatMessages(older), "\n") + 2
+		m.history = append(append([]Message(nil), older...), m.history...)
+		m.oldestLoaded = oldestTimestamp(older)
+		if m.isSelectMode {
+			m.selectedMessage += len(older)
 		}
 
+		offset := m.viewport.YOffset
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.SetYOffset(offset + addedLines)
+
 	case error:
 		m.err = msg
 		return m, nil
 	}
 
+	var loadCmd tea.Cmd
+	if m.viewport.AtTop() && !m.loadingOlder && !m.noMoreOlder {
+		m.loadingOlder = true
+		loadCmd = loadOlderCmd(m.room, m.oldestLoaded)
+	}
+
 	return m, tea.Batch(
 		tiCmd,
 		vpCmd,
-		m.checkNewMessages,
+		loadCmd,
 	)
 }
-func (m model) formatMessages(messages []Message) string {
+
+// olderMessagesMsg carries a lazily-fetched page of history to prepend
+// once the user scrolls the viewport to the top.
+type olderMessagesMsg []Message
+
+// loadOlderCmd fetches the page of messages immediately before before
+// in room, off the UI thread.
+func loadOlderCmd(room *Room, before time.Time) tea.Cmd {
+	return func() tea.Msg {
+		older, err := room.LoadOlder(before)
+		if err != nil {
+			log.Error("failed to load older messages", "room", room.name, "error", err)
+			return olderMessagesMsg(nil)
+		}
+		return olderMessagesMsg(older)
+	}
+}
+
+// formatMessages renders messages for the viewport, reusing a cached
+// render for any message whose UniqueID, vote tallies, selection state
+// and viewport width all still match what's in m.renderCache.
+func (m *model) formatMessages(messages []Message) string {
 	var formattedMessages []string
 	for i, msg := range messages {
 		if m.isSelectMode && msg.System {
 			continue // Skip system messages in selection mode
 		}
 
-		var messageStyle lipgloss.Style
-		var boxStyle lipgloss.Style
-
 		if msg.System {
-			// Style for system messages
-			messageStyle = lipgloss.NewStyle().Foreground(subtext0).Italic(true)
-			formattedMessages = append(formattedMessages, messageStyle.Render(msg.Content))
+			formattedMessages = append(formattedMessages, systemStyle.Render(msg.Content))
 			continue
 		}
 
-		if m.isSelectMode && i == m.selectedMessage {
-			boxStyle = lipgloss.NewStyle().
-				Border(lipgloss.RoundedBorder()).
-				BorderForeground(red).
-				Padding(0, 1).
-				Margin(0, 0, 1, 0)
+		selected := m.isSelectMode && i == m.selectedMessage
+		formattedMessages = append(formattedMessages, m.renderMessage(msg, selected))
+	}
+	return strings.Join(formattedMessages, "\n")
+}
 
-			messageStyle = lipgloss.NewStyle().
-				Background(selected).
-				Foreground(text).
-				Padding(0, 1)
+// renderMessage returns msg's formatted box, from m.renderCache if
+// nothing relevant has changed since it was last built there.
+func (m *model) renderMessage(msg Message, selected bool) string {
+	width := m.viewport.Width
+
+	if cached, ok := m.renderCache[msg.UniqueID]; ok &&
+		cached.upvotes == msg.Upvotes &&
+		cached.downvotes == msg.Downvotes &&
+		cached.selected == selected &&
+		cached.width == width {
+		return cached.rendered
+	}
+
+	rendered := m.renderMessageBox(msg, selected, width)
+	m.renderCache[msg.UniqueID] = cachedRender{
+		upvotes:   msg.Upvotes,
+		downvotes: msg.Downvotes,
+		selected:  selected,
+		width:     width,
+		rendered:  rendered,
+	}
+	return rendered
+}
+
+// renderMessageBox does the actual formatting work behind renderMessage:
+// word-wrapping (or, for content with a code fence, glamour markdown
+// rendering) to fit width, then the header and box styling.
+func (m *model) renderMessageBox(msg Message, selected bool, width int) string {
+	wrapWidth := width - boxPadding
+	if wrapWidth < 1 {
+		wrapWidth = 1
+	}
+
+	content := msg.Content
+	if strings.Contains(content, "```") {
+		if rendered, err := renderMarkdown(content, wrapWidth); err == nil {
+			content = strings.TrimRight(rendered, "\n")
 		} else {
-			boxStyle = messageBoxStyle
-			messageStyle = lipgloss.NewStyle().
-				Foreground(text)
+			content = wordwrap.String(content, wrapWidth)
 		}
+	} else {
+		content = wordwrap.String(content, wrapWidth)
+	}
 
-		header := fmt.Sprintf("%s %s %s | %s %s",
-			userNameStyle.Render(msg.Username),
-			timestampStyle.Render(msg.Timestamp),
-			pubKeyStyle.Render("("+msg.PubKey+")"),
-			upvoteStyle.Render(fmt.Sprintf("👍 %d", msg.Upvotes)),
-			downvoteStyle.Render(fmt.Sprintf("👎 %d", msg.Downvotes)),
-		)
+	boxStyle, msgStyle := messageBoxStyle, messageStyle
+	if selected {
+		boxStyle, msgStyle = selectedBoxStyle, selectedMessageStyle
+	}
 
-		formattedMessage := boxStyle.Render(
-			header + "\n" + messageStyle.Render(msg.Content),
-		)
-		formattedMessages = append(formattedMessages, formattedMessage)
+	parts := []string{userNameStyle.Render(msg.Username)}
+	if ts := m.formatTimestamp(msg); ts != "" {
+		parts = append(parts, timestampStyle.Render(ts))
+	}
+	parts = append(parts,
+		pubKeyStyle.Render("("+msg.PubKey+")")+" |",
+		upvoteStyle.Render(fmt.Sprintf("👍 %d", msg.Upvotes)),
+		downvoteStyle.Render(fmt.Sprintf("👎 %d", msg.Downvotes)),
+	)
+	header := strings.Join(parts, " ")
+
+	return boxStyle.Render(header + "\n" + msgStyle.Render(content))
+}
+
+// formatTimestamp renders msg's time according to m.timestampMode: off
+// (empty), short (15:04), or full (msg.Timestamp, in messageTimeLayout).
+func (m *model) formatTimestamp(msg Message) string {
+	switch m.timestampMode {
+	case timestampOff:
+		return ""
+	case timestampShort:
+		return msg.CreatedAt.Format("15:04")
+	default:
+		return msg.Timestamp
 	}
-	return strings.Join(formattedMessages, "\n")
 }
 
 func (m model) View() string {
@@ -472,20 +1373,15 @@ func (m model) View() string {
 	)
 
 	termInfo := m.textStyle.Render(fmt.Sprintf(
-		"Connected as: %s | Term: %s | Window: %dx%d | Online users: %d",
-		m.session.User(), m.term, m.width, m.height, onlineUsers,
+		"Connected as: %s | Room: %s | Term: %s | Window: %dx%d | Online users: %d",
+		displayName(m.fingerprint, m.session.User()), m.roomName, m.term, m.width, m.height, onlineUsers,
 	))
 
-	var modeInfo string
+	mode := "INPUT MODE"
 	if m.isSelectMode {
-		modeInfo = m.quitStyle.Render(
-			"SELECTION MODE: ↑/↓ to navigate | 'u' to upvote | 'd' to downvote | TAB to exit",
-		)
-	} else {
-		modeInfo = m.quitStyle.Render(
-			"INPUT MODE: Type message | TAB to select messages | 'Esc' or 'Ctrl+C' to quit",
-		)
+		mode = "SELECTION MODE"
 	}
+	modeInfo := m.quitStyle.Render(mode) + "  " + m.help.View(m.keys)
 
 	return chatView + "\n" + termInfo + "\n" + modeInfo
 }
@@ -0,0 +1,252 @@
+// Package moderation implements a persistent banlist, admin list and
+// optional whitelist for the chat server. State is kept in memory and
+// flushed to a single JSON file on every mutation so it survives a
+// restart.
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyType identifies what a Ban or whitelist entry matches against.
+type KeyType string
+
+const (
+	KeyFingerprint KeyType = "fingerprint"
+	KeyIP          KeyType = "ip"
+	KeyUsername    KeyType = "username"
+)
+
+// Ban is a single banlist entry. A zero ExpiresAt means the ban never
+// expires; otherwise it is evaluated lazily the next time IsBanned is
+// called.
+type Ban struct {
+	Type      KeyType   `json:"type"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && now.After(b.ExpiresAt)
+}
+
+func (b Ban) matches(fingerprint, ip, username string) bool {
+	switch b.Type {
+	case KeyFingerprint:
+		return fingerprint != "" && b.Value == fingerprint
+	case KeyIP:
+		return ip != "" && b.Value == ip
+	case KeyUsername:
+		return username != "" && strings.EqualFold(b.Value, username)
+	default:
+		return false
+	}
+}
+
+// state is the on-disk JSON representation of a Store.
+type state struct {
+	Bans             []Ban    `json:"bans"`
+	Admins           []string `json:"admins"`
+	Whitelist        []string `json:"whitelist"`
+	WhitelistEnabled bool     `json:"whitelist_enabled"`
+}
+
+// Store is the in-memory, disk-backed set of bans, admins and the
+// optional whitelist. All methods are safe for concurrent use.
+type Store struct {
+	path string
+
+	mutex            sync.Mutex
+	bans             []Ban
+	admins           map[string]bool
+	whitelist        map[string]bool
+	whitelistEnabled bool
+
+	onBan func(Ban)
+}
+
+// Load reads path into a Store, treating a missing file as an empty
+// store rather than an error.
+func Load(path string) (*Store, error) {
+	s := &Store{
+		path:      path,
+		admins:    make(map[string]bool),
+		whitelist: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+
+	s.bans = st.Bans
+	s.whitelistEnabled = st.WhitelistEnabled
+	for _, fp := range st.Admins {
+		s.admins[fp] = true
+	}
+	for _, key := range st.Whitelist {
+		s.whitelist[key] = true
+	}
+	return s, nil
+}
+
+// save writes the store to disk. Callers must hold s.mutex.
+func (s *Store) save() error {
+	st := state{
+		Bans:             s.bans,
+		WhitelistEnabled: s.whitelistEnabled,
+	}
+	for fp := range s.admins {
+		st.Admins = append(st.Admins, fp)
+	}
+	for key := range s.whitelist {
+		st.Whitelist = append(st.Whitelist, key)
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o600)
+}
+
+// OnBan registers fn to be called, outside of the store's lock, after
+// a ban is successfully persisted. The caller uses this to drop any
+// live sessions the new ban now matches.
+func (s *Store) OnBan(fn func(Ban)) {
+	s.mutex.Lock()
+	s.onBan = fn
+	s.mutex.Unlock()
+}
+
+// Ban adds a new ban and persists it. duration <= 0 means permanent.
+func (s *Store) Ban(typ KeyType, value, reason string, duration time.Duration) error {
+	s.mutex.Lock()
+
+	ban := Ban{Type: typ, Value: value, Reason: reason, CreatedAt: time.Now()}
+	if duration > 0 {
+		ban.ExpiresAt = ban.CreatedAt.Add(duration)
+	}
+	s.bans = append(s.bans, ban)
+
+	err := s.save()
+	onBan := s.onBan
+	s.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if onBan != nil {
+		onBan(ban)
+	}
+	return nil
+}
+
+// Unban removes every ban matching typ and value.
+func (s *Store) Unban(typ KeyType, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kept := s.bans[:0]
+	for _, b := range s.bans {
+		if b.Type == typ && b.Value == value {
+			continue
+		}
+		kept = append(kept, b)
+	}
+	s.bans = kept
+	return s.save()
+}
+
+// IsBanned reports whether fingerprint, ip or username matches a live
+// ban, pruning any expired entries it encounters along the way.
+func (s *Store) IsBanned(fingerprint, ip, username string) (Ban, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	live := s.bans[:0]
+	var match Ban
+	found := false
+
+	for _, b := range s.bans {
+		if b.expired(now) {
+			continue
+		}
+		live = append(live, b)
+
+		if !found && b.matches(fingerprint, ip, username) {
+			match = b
+			found = true
+		}
+	}
+
+	if len(live) != len(s.bans) {
+		s.bans = live
+		_ = s.save()
+	}
+
+	return match, found
+}
+
+// IsAdmin reports whether fingerprint is in the admin list.
+func (s *Store) IsAdmin(fingerprint string) bool {
+	if fingerprint == "" {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.admins[fingerprint]
+}
+
+// AddAdmin grants fingerprint admin rights.
+func (s *Store) AddAdmin(fingerprint string) error {
+	s.mutex.Lock()
+	s.admins[fingerprint] = true
+	err := s.save()
+	s.mutex.Unlock()
+	return err
+}
+
+// WhitelistEnabled reports whether whitelist mode is currently on.
+func (s *Store) WhitelistEnabled() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.whitelistEnabled
+}
+
+// IsWhitelisted reports whether the connection should be allowed.
+// When whitelist mode is off, everyone is allowed.
+func (s *Store) IsWhitelisted(fingerprint, username string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.whitelistEnabled {
+		return true
+	}
+	return s.whitelist[fingerprint] || s.whitelist[strings.ToLower(username)]
+}
+
+// AddWhitelist adds key (a fingerprint or lowercase username) to the
+// whitelist.
+func (s *Store) AddWhitelist(key string) error {
+	s.mutex.Lock()
+	s.whitelist[strings.ToLower(key)] = true
+	err := s.save()
+	s.mutex.Unlock()
+	return err
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+
+	"thehiddenweb/store"
+)
+
+// defaultRoom is the room every session joins on connect.
+const defaultRoom = "#main"
+
+// pageSize is how many older messages a single lazy-load fetches.
+const pageSize = 50
+
+// msgStore is the durable backend every Room reads through and writes
+// to; main sets it once at startup before the server accepts
+// connections.
+var msgStore store.Store
+
+// subscriber is a live session listening for pushes from a Room.
+type subscriber struct {
+	username string
+	ch       chan Message
+}
+
+// Room is an isolated chat channel: a name, its live subscribers, and
+// the durable history and votes held in msgStore. All mutation goes
+// through its methods, which take care of their own locking.
+type Room struct {
+	name string
+
+	subMutex    sync.Mutex
+	subscribers map[string]*subscriber // sessionID -> subscriber
+}
+
+func newRoom(name string) *Room {
+	return &Room{
+		name:        name,
+		subscribers: make(map[string]*subscriber),
+	}
+}
+
+var (
+	rooms      = map[string]*Room{defaultRoom: newRoom(defaultRoom)}
+	roomsMutex = &sync.Mutex{}
+)
+
+// maxRoomNameLength bounds a user-supplied /join target; defaultRoom is
+// exempt since it's created directly, not through validateRoomName.
+const maxRoomNameLength = 32
+
+// roomNameChars are the only characters allowed in a user-supplied room
+// name, besides the '#' defaultRoom itself starts with.
+const roomNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
+
+// validateRoomName rejects anything too long or containing characters
+// that would make '/rooms' output or log lines hard to read.
+func validateRoomName(name string) error {
+	trimmed := strings.TrimPrefix(name, "#")
+	if trimmed == "" || len(name) > maxRoomNameLength {
+		return errors.New("room name must be 1-32 characters")
+	}
+	if strings.Trim(trimmed, roomNameChars) != "" {
+		return errors.New("room name must be alphanumeric (with _ and -)")
+	}
+	return nil
+}
+
+// getOrCreateRoom returns the named room, creating it if this is the
+// first time anyone has referenced it.
+func getOrCreateRoom(name string) *Room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	r, ok := rooms[name]
+	if !ok {
+		r = newRoom(name)
+		rooms[name] = r
+	}
+	return r
+}
+
+// evictIfEmpty removes this room from the registry once it has no
+// subscribers left, so an endless stream of one-off /join targets
+// doesn't grow the registry (and '/rooms' output) without bound, the
+// way messageCache is already bounded by cacheRooms. defaultRoom is
+// exempt: it always exists, even with nobody in it.
+func (r *Room) evictIfEmpty() {
+	if r.name == defaultRoom {
+		return
+	}
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	if r.onlineCount() == 0 {
+		delete(rooms, r.name)
+	}
+}
+
+// listRooms returns each room's online count, keyed by room name. The
+// map is unordered; callers that display it sort the names themselves.
+func listRooms() map[string]int {
+	roomsMutex.Lock()
+	names := make([]*Room, 0, len(rooms))
+	for _, r := range rooms {
+		names = append(names, r)
+	}
+	roomsMutex.Unlock()
+
+	counts := make(map[string]int, len(names))
+	for _, r := range names {
+		counts[r.name] = r.onlineCount()
+	}
+	return counts
+}
+
+// newSessionID returns a short random identifier used to key a
+// session's subscription, independent of its display username.
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// Subscribe registers sessionID to receive pushes for this room and
+// returns the channel it will receive on. The channel is closed by
+// Unsubscribe, so a blocked receive unblocks on leave.
+func (r *Room) Subscribe(sessionID, username string) chan Message {
+	ch := make(chan Message, 32)
+
+	r.subMutex.Lock()
+	r.subscribers[sessionID] = &subscriber{username: username, ch: ch}
+	r.subMutex.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes sessionID from the room and closes its channel,
+// evicting the room from the registry if that was its last subscriber.
+func (r *Room) Unsubscribe(sessionID string) {
+	r.subMutex.Lock()
+	sub, ok := r.subscribers[sessionID]
+	if ok {
+		delete(r.subscribers, sessionID)
+		close(sub.ch)
+	}
+	r.subMutex.Unlock()
+
+	if ok {
+		r.evictIfEmpty()
+	}
+}
+
+// Broadcast fans a message out to every current subscriber. A
+// subscriber whose channel buffer is full misses this message rather
+// than blocking the room; it stays subscribed.
+func (r *Room) Broadcast(msg Message) {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+
+	for _, sub := range r.subscribers {
+		select {
+		case sub.ch <- msg:
+		default:
+		}
+	}
+}
+
+// Whisper delivers msg only to sessions in this room whose username
+// matches target (case-insensitively). It reports whether anyone was
+// found.
+func (r *Room) Whisper(target string, msg Message) bool {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+
+	delivered := false
+	for _, sub := range r.subscribers {
+		if !strings.EqualFold(sub.username, target) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// Rename updates the display name this room reports in Who() for
+// sessionID, e.g. after a /nick change takes effect.
+func (r *Room) Rename(sessionID, username string) {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+
+	if sub, ok := r.subscribers[sessionID]; ok {
+		sub.username = username
+	}
+}
+
+// onlineCount returns the number of sessions currently subscribed.
+func (r *Room) onlineCount() int {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+	return len(r.subscribers)
+}
+
+// Who returns the usernames currently subscribed to this room, sorted.
+func (r *Room) Who() []string {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+
+	names := make([]string, 0, len(r.subscribers))
+	for _, sub := range r.subscribers {
+		names = append(names, sub.username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddMessage persists a message to msgStore, records it in the hot
+// cache, and broadcasts it to every subscriber. If persistence fails,
+// the message is neither cached nor broadcast: showing it live while
+// silently failing to save it would make it vanish on reconnect.
+func (r *Room) AddMessage(session ssh.Session, content string, system bool) (Message, error) {
+	msg := buildMessage(session, content, system)
+
+	if err := msgStore.Append(toStoreMessage(r.name, msg)); err != nil {
+		log.Error("failed to persist message", "room", r.name, "error", err)
+		return Message{}, err
+	}
+
+	messageCacheInstance.append(r.name, msg)
+	r.Broadcast(msg)
+	return msg, nil
+}
+
+// GetMessages returns the room's hot window: its most recent messages,
+// served from cache when possible and lazily populated from msgStore
+// otherwise.
+func (r *Room) GetMessages() []Message {
+	if cached, ok := messageCacheInstance.get(r.name); ok {
+		return cached
+	}
+
+	stored, err := msgStore.Range(r.name, time.Now(), hotWindow)
+	if err != nil {
+		log.Error("failed to load room history", "room", r.name, "error", err)
+		return nil
+	}
+
+	messages := make([]Message, len(stored))
+	for i, m := range stored {
+		messages[i] = fromStoreMessage(m)
+	}
+	messageCacheInstance.set(r.name, messages)
+	return messages
+}
+
+// LoadOlder fetches the page of up to pageSize messages immediately
+// before in this room, bypassing the hot cache.
+func (r *Room) LoadOlder(before time.Time) ([]Message, error) {
+	stored, err := msgStore.Range(r.name, before, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, len(stored))
+	for i, m := range stored {
+		messages[i] = fromStoreMessage(m)
+	}
+	return messages, nil
+}
+
+// Vote registers username's vote on messageID, persists the new
+// tallies, and broadcasts the updated message so every subscriber
+// (including the voter) sees the live count.
+func (r *Room) Vote(username, messageID string, voteType int) (Message, error) {
+	stored, err := msgStore.Vote(r.name, username, messageID, voteType)
+	if err != nil {
+		return Message{}, mapStoreErr(err)
+	}
+
+	updated := fromStoreMessage(stored)
+	updated.Updated = true
+
+	messageCacheInstance.updateMessage(r.name, updated)
+	r.Broadcast(updated)
+	return updated, nil
+}
+
+// mapStoreErr translates store sentinel errors to the chat package's
+// own, which the rest of the code already checks against.
+func mapStoreErr(err error) error {
+	switch {
+	case errors.Is(err, store.ErrAlreadyVoted):
+		return errAlreadyVoted
+	case errors.Is(err, store.ErrMessageNotFound):
+		return errMessageNotFound
+	default:
+		return err
+	}
+}
+
+// toStoreMessage and fromStoreMessage convert between the chat
+// package's display-oriented Message (string timestamp, room implicit)
+// and the store package's persistence-oriented one.
+func toStoreMessage(room string, msg Message) store.Message {
+	return store.Message{
+		ID:        msg.UniqueID,
+		Room:      room,
+		Username:  msg.Username,
+		PubKey:    msg.PubKey,
+		Content:   msg.Content,
+		System:    msg.System,
+		CreatedAt: msg.CreatedAt,
+		Upvotes:   msg.Upvotes,
+		Downvotes: msg.Downvotes,
+	}
+}
+
+func fromStoreMessage(msg store.Message) Message {
+	return Message{
+		Username:  msg.Username,
+		Timestamp: msg.CreatedAt.Format(messageTimeLayout),
+		CreatedAt: msg.CreatedAt,
+		PubKey:    msg.PubKey,
+		Content:   msg.Content,
+		Upvotes:   msg.Upvotes,
+		Downvotes: msg.Downvotes,
+		UniqueID:  msg.ID,
+		System:    msg.System,
+	}
+}
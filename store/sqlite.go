@@ -0,0 +1,230 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is a Store backed by a single SQLite database file.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		id         TEXT NOT NULL,
+		room       TEXT NOT NULL,
+		username   TEXT NOT NULL,
+		pubkey     TEXT NOT NULL,
+		content    TEXT NOT NULL,
+		system     INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		upvotes    INTEGER NOT NULL DEFAULT 0,
+		downvotes  INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (room, id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_room_created_at ON messages(room, created_at)`,
+	`CREATE TABLE IF NOT EXISTS votes (
+		room       TEXT NOT NULL,
+		username   TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		value      INTEGER NOT NULL,
+		PRIMARY KEY (room, username, message_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS profiles (
+		pubkey         TEXT NOT NULL PRIMARY KEY,
+		nick           TEXT NOT NULL DEFAULT '',
+		timestamp_mode INTEGER NOT NULL DEFAULT 0
+	)`,
+}
+
+// OpenSQLite opens (creating if necessary) the SQLite database at path
+// and brings its schema up to date.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// modernc.org/sqlite serializes access per *sql.DB; a single
+	// connection avoids "database is locked" errors under concurrent
+	// writers.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	var applied int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		return err
+	}
+
+	for i := applied; i < len(migrations); i++ {
+		if _, err := s.db.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+		if _, err := s.db.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i); err != nil {
+			return fmt.Errorf("recording migration %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Append(msg Message) error {
+	_, err := s.db.Exec(
+		`INSERT INTO messages (id, room, username, pubkey, content, system, created_at, upvotes, downvotes)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ID, msg.Room, msg.Username, msg.PubKey, msg.Content, msg.System,
+		msg.CreatedAt.UnixNano(), msg.Upvotes, msg.Downvotes,
+	)
+	return err
+}
+
+func (s *SQLiteStore) Range(room string, before time.Time, limit int) ([]Message, error) {
+	rows, err := s.db.Query(
+		`SELECT id, room, username, pubkey, content, system, created_at, upvotes, downvotes
+		 FROM messages WHERE room = ? AND created_at < ? ORDER BY created_at DESC LIMIT ?`,
+		room, before.UnixNano(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []Message
+	for rows.Next() {
+		var msg Message
+		var createdAt int64
+		if err := rows.Scan(&msg.ID, &msg.Room, &msg.Username, &msg.PubKey, &msg.Content,
+			&msg.System, &createdAt, &msg.Upvotes, &msg.Downvotes); err != nil {
+			return nil, err
+		}
+		msg.CreatedAt = time.Unix(0, createdAt)
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+func (s *SQLiteStore) Vote(room, user, messageID string, delta int) (Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+
+	var prev int
+	err = tx.QueryRow(
+		`SELECT value FROM votes WHERE room = ? AND username = ? AND message_id = ?`,
+		room, user, messageID,
+	).Scan(&prev)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		prev = 0
+		var exists int
+		if err := tx.QueryRow(`SELECT 1 FROM messages WHERE room = ? AND id = ?`, room, messageID).Scan(&exists); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return Message{}, ErrMessageNotFound
+			}
+			return Message{}, err
+		}
+	case err != nil:
+		return Message{}, err
+	default:
+		if prev == delta {
+			return Message{}, ErrAlreadyVoted
+		}
+	}
+
+	upDelta, downDelta := 0, 0
+	if prev > 0 {
+		upDelta--
+	} else if prev < 0 {
+		downDelta--
+	}
+	if delta > 0 {
+		upDelta++
+	} else {
+		downDelta++
+	}
+
+	res, err := tx.Exec(
+		`UPDATE messages SET upvotes = upvotes + ?, downvotes = downvotes + ? WHERE room = ? AND id = ?`,
+		upDelta, downDelta, room, messageID,
+	)
+	if err != nil {
+		return Message{}, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return Message{}, ErrMessageNotFound
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO votes (room, username, message_id, value) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(room, username, message_id) DO UPDATE SET value = excluded.value`,
+		room, user, messageID, delta,
+	); err != nil {
+		return Message{}, err
+	}
+
+	var msg Message
+	var createdAt int64
+	if err := tx.QueryRow(
+		`SELECT id, room, username, pubkey, content, system, created_at, upvotes, downvotes
+		 FROM messages WHERE room = ? AND id = ?`, room, messageID,
+	).Scan(&msg.ID, &msg.Room, &msg.Username, &msg.PubKey, &msg.Content,
+		&msg.System, &createdAt, &msg.Upvotes, &msg.Downvotes); err != nil {
+		return Message{}, err
+	}
+	msg.CreatedAt = time.Unix(0, createdAt)
+
+	return msg, tx.Commit()
+}
+
+func (s *SQLiteStore) GetProfile(pubKey string) (Profile, bool, error) {
+	var p Profile
+	err := s.db.QueryRow(
+		`SELECT pubkey, nick, timestamp_mode FROM profiles WHERE pubkey = ?`, pubKey,
+	).Scan(&p.PubKey, &p.Nick, &p.TimestampMode)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, false, nil
+	}
+	if err != nil {
+		return Profile{}, false, err
+	}
+	return p, true, nil
+}
+
+func (s *SQLiteStore) SetProfile(p Profile) error {
+	_, err := s.db.Exec(
+		`INSERT INTO profiles (pubkey, nick, timestamp_mode) VALUES (?, ?, ?)
+		 ON CONFLICT(pubkey) DO UPDATE SET nick = excluded.nick, timestamp_mode = excluded.timestamp_mode`,
+		p.PubKey, p.Nick, p.TimestampMode,
+	)
+	return err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
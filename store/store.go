@@ -0,0 +1,65 @@
+// Package store defines the durable backend for chat history and vote
+// tallies, and ships a SQLite-backed implementation of it.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAlreadyVoted    = errors.New("you have already voted this way")
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// Message is a single persisted chat line, system notice, or vote
+// tally. Unlike the chat package's own Message, it carries no
+// preformatted display timestamp: CreatedAt alone, which Range
+// paginates on.
+type Message struct {
+	ID        string
+	Room      string
+	Username  string
+	PubKey    string
+	Content   string
+	System    bool
+	CreatedAt time.Time
+	Upvotes   int
+	Downvotes int
+}
+
+// Profile is a user's cross-session identity: a chosen nickname and
+// display preferences, keyed by the SHA256 fingerprint of their public
+// key so they survive reconnecting under a different SSH username.
+type Profile struct {
+	PubKey        string
+	Nick          string
+	TimestampMode int
+}
+
+// Store is the pluggable durable backend for a chat server's history,
+// votes, and per-user profiles.
+type Store interface {
+	// Append persists msg.
+	Append(msg Message) error
+
+	// Range returns up to limit messages from room older than before,
+	// in chronological order.
+	Range(room string, before time.Time, limit int) ([]Message, error)
+
+	// Vote registers user's vote on messageID within room and returns
+	// the message with updated tallies. It returns ErrAlreadyVoted if
+	// user already voted this way, or ErrMessageNotFound if no such
+	// message exists in room.
+	Vote(room, user, messageID string, delta int) (Message, error)
+
+	// GetProfile returns the profile stored for pubKey, or ok=false if
+	// none has been saved yet.
+	GetProfile(pubKey string) (profile Profile, ok bool, err error)
+
+	// SetProfile persists profile, keyed by its PubKey.
+	SetProfile(profile Profile) error
+
+	// Close releases any resources held by the store.
+	Close() error
+}